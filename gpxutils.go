@@ -1,17 +1,615 @@
 package main
 
 import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
-	"os"
-	"path/filepath"
 	"math" // Added for math operations
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/tkrajina/gpxgo/gpx"
 )
 
 const numTargetPoints = 1000
 
-func normalizeGPX(inputFile string, outputFile string) error {
+// Resampling modes supported by normalizeGPX. mode2D walks the flattened
+// 2D (lat/lon only) track length, mode3D walks the true 3D arc length by
+// folding elevation change into the per-segment distance, modeTime spaces
+// points evenly in time using the source timestamps instead of distance,
+// and modeSimplify runs Ramer-Douglas-Peucker instead of equidistant
+// resampling, producing a variable number of points bounded by an epsilon
+// tolerance (or a target count) rather than a fixed numTargetPoints.
+const (
+	mode2D       = "2d"
+	mode3D       = "3d"
+	modeTime     = "time"
+	modeSimplify = "simplify"
+)
+
+// normalizeOptions bundles the flags that shape how normalizeGPX resamples
+// a file.
+type normalizeOptions struct {
+	// mode selects the distance metric: mode2D, mode3D, modeTime, or
+	// modeSimplify.
+	mode string
+	// perFile, when true, splits numTargetPoints across every segment/route
+	// in the file proportionally to its share of the total length (or
+	// duration, in modeTime) instead of resampling each one independently
+	// to numTargetPoints. Not used in modeSimplify.
+	perFile bool
+	// epsilon is the simplification tolerance in meters, used when
+	// mode is modeSimplify and targetPoints is 0.
+	epsilon float64
+	// targetPoints, when > 0 and mode is modeSimplify, binary-searches
+	// epsilon per segment/route to hit approximately this many points
+	// instead of using epsilon directly.
+	targetPoints int
+	// onInvalid selects how validateSourcePoints handles a point that
+	// fails validation: onInvalidError, onInvalidDrop, or onInvalidClamp.
+	// The zero value ("") skips validation entirely, so existing callers
+	// that don't opt in are unaffected.
+	onInvalid string
+	// maxJumpMeters, when > 0, rejects a point whose great-circle distance
+	// from the previous kept point exceeds it, catching GPS glitches.
+	// 0 disables the jump check.
+	maxJumpMeters float64
+	// outputFormat selects the writer normalizeGPX hands the resampled
+	// points to: outputFormatGPX (the zero value behaves the same way),
+	// outputFormatGeoJSON, or outputFormatCSV.
+	outputFormat string
+}
+
+// unit is a single resamplable polyline within a GPX file: either a track
+// segment or a route. describe names it for error messages, and name is
+// the source track/route's name (may be empty), carried through to
+// output formats like GeoJSON that want to label each feature.
+type unit struct {
+	points   []gpx.GPXPoint
+	describe string
+	name     string
+}
+
+// on-invalid policies for validateSourcePoints.
+const (
+	onInvalidError = "error"
+	onInvalidDrop  = "drop"
+	onInvalidClamp = "clamp"
+)
+
+// ValidationError reports a source point that failed validateSourcePoints
+// under onInvalidError, naming its index within the unit so callers and
+// tests can assert on the specific failure.
+type ValidationError struct {
+	Index  int
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid point at index %d: %s", e.Index, e.Reason)
+}
+
+// clampToRange snaps v into [lo, hi].
+func clampToRange(v, lo, hi float64) float64 {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// validateSourcePoints applies opts.onInvalid to points before resampling,
+// rejecting or fixing up points with out-of-range coordinates (|lat| > 90
+// or |lon| > 180), points sitting exactly on (0, 0) (a common sentinel for
+// a missing GPS fix), and, when opts.maxJumpMeters > 0, points whose
+// great-circle distance from the previous *kept* point exceeds it (a GPS
+// glitch). Under onInvalidError it returns a *ValidationError naming the
+// offending index; under onInvalidDrop it omits the point; under
+// onInvalidClamp it snaps out-of-range coordinates into range, but still
+// drops a (0, 0) or jump violation since there is nothing sane to clamp
+// those to. A zero-value opts.onInvalid skips validation and returns
+// points unchanged.
+func validateSourcePoints(points []gpx.GPXPoint, opts normalizeOptions) ([]gpx.GPXPoint, error) {
+	if opts.onInvalid == "" {
+		return points, nil
+	}
+
+	validated := make([]gpx.GPXPoint, 0, len(points))
+	var prev *gpx.GPXPoint
+
+	for i, p := range points {
+		outOfRange := math.Abs(p.Latitude) > 90 || math.Abs(p.Longitude) > 180
+		reason := ""
+		switch {
+		case outOfRange:
+			reason = fmt.Sprintf("latitude/longitude out of range (%g, %g)", p.Latitude, p.Longitude)
+		case p.Latitude == 0 && p.Longitude == 0:
+			reason = "latitude and longitude are both exactly 0"
+		case prev != nil && opts.maxJumpMeters > 0:
+			jump := gpx.HaversineDistance(prev.Latitude, prev.Longitude, p.Latitude, p.Longitude)
+			if jump > opts.maxJumpMeters {
+				reason = fmt.Sprintf("jump of %.1fm from previous point exceeds max-jump-meters (%.1f)", jump, opts.maxJumpMeters)
+			}
+		}
+
+		if reason == "" {
+			validated = append(validated, p)
+			prev = &validated[len(validated)-1]
+			continue
+		}
+
+		switch opts.onInvalid {
+		case onInvalidError:
+			return nil, &ValidationError{Index: i, Reason: reason}
+		case onInvalidClamp:
+			if !outOfRange {
+				// (0, 0) and jump violations have nothing sane to clamp to; drop them.
+				continue
+			}
+			p.Latitude = clampToRange(p.Latitude, -90, 90)
+			p.Longitude = clampToRange(p.Longitude, -180, 180)
+			validated = append(validated, p)
+			prev = &validated[len(validated)-1]
+		default: // onInvalidDrop
+			continue
+		}
+	}
+
+	return validated, nil
+}
+
+// distanceFunc computes the distance between two adjacent points using
+// whichever metric the active resampling mode requires. Both the total
+// length calculation and the point-walking loop below go through this so
+// 2D and 3D resampling share a single algorithm.
+type distanceFunc func(p1, p2 *gpx.GPXPoint) float64
+
+// distance2D is the flat-earth lat/lon distance already used by the
+// original resampler.
+func distance2D(p1, p2 *gpx.GPXPoint) float64 {
+	return p1.Distance2D(p2)
+}
+
+// distance3D combines the great-circle distance between p1 and p2 with
+// their elevation delta, so resampling walks the true 3D arc length
+// instead of the flattened 2D one. Falls back to the great-circle distance
+// alone when either point lacks a valid elevation.
+func distance3D(p1, p2 *gpx.GPXPoint) float64 {
+	flat := gpx.HaversineDistance(p1.Latitude, p1.Longitude, p2.Latitude, p2.Longitude)
+	if !p1.Elevation.NotNull() || !p2.Elevation.NotNull() {
+		return flat
+	}
+	dEle := p2.Elevation.Value() - p1.Elevation.Value()
+	return math.Sqrt(flat*flat + dEle*dEle)
+}
+
+// distanceFuncForMode returns the distanceFunc to use for the given
+// resampling mode, defaulting to 2D for an empty or unrecognized mode.
+func distanceFuncForMode(mode string) distanceFunc {
+	if mode == mode3D {
+		return distance3D
+	}
+	return distance2D
+}
+
+// totalLength sums distFn across consecutive points, the 3D-aware
+// counterpart to segment.Length2D().
+func totalLength(points []gpx.GPXPoint, distFn distanceFunc) float64 {
+	total := 0.0
+	for i := 0; i < len(points)-1; i++ {
+		total += distFn(&points[i], &points[i+1])
+	}
+	return total
+}
+
+// resampleEquidistant walks sourcePoints using distFn and returns
+// targetCount points evenly spaced according to that distance metric.
+func resampleEquidistant(sourcePoints []gpx.GPXPoint, distFn distanceFunc, targetCount int) []gpx.GPXPoint {
+	newPoints := make([]gpx.GPXPoint, 0, targetCount)
+
+	totalDistance := totalLength(sourcePoints, distFn)
+
+	// Handle zero total distance
+	if totalDistance == 0 {
+		firstPoint := sourcePoints[0]
+		for i := 0; i < targetCount; i++ {
+			newPoints = append(newPoints, firstPoint)
+		}
+		return newPoints
+	}
+
+	intervalDistance := totalDistance / float64(targetCount-1)
+	cumulativeDistance := 0.0
+	originalPointIndex := 0
+
+	for i := 0; i < targetCount; i++ {
+		var newPoint gpx.GPXPoint
+
+		if i == 0 {
+			newPoint = sourcePoints[0]
+		} else if i == targetCount-1 {
+			newPoint = sourcePoints[len(sourcePoints)-1]
+		} else {
+			targetDistForCurrentPoint := float64(i) * intervalDistance
+
+			// Advance originalPointIndex:
+			// Loop while originalPointIndex is not the second to last point AND
+			// the next segment's end (cumulativeDistance + distance to next point) is still less than our target.
+			for originalPointIndex < len(sourcePoints)-2 && // Ensures sourcePoints[originalPointIndex+1] is valid
+				cumulativeDistance+distFn(&sourcePoints[originalPointIndex], &sourcePoints[originalPointIndex+1]) < targetDistForCurrentPoint {
+				cumulativeDistance += distFn(&sourcePoints[originalPointIndex], &sourcePoints[originalPointIndex+1])
+				originalPointIndex++
+			}
+
+			p1 := sourcePoints[originalPointIndex]
+			p2 := sourcePoints[originalPointIndex+1] // Safe because originalPointIndex <= len(sourcePoints)-2
+
+			distToP1 := cumulativeDistance // Cumulative distance *to the start of the current segment (p1)*
+			distP1P2 := distFn(&p1, &p2)
+
+			ratio := 0.0
+			if distP1P2 > 0 {
+				// ratio is how far along the segment (p1 to p2) our targetDistForCurrentPoint falls
+				ratio = (targetDistForCurrentPoint - distToP1) / distP1P2
+			}
+			// Clamp ratio to [0, 1] to handle floating point inaccuracies or edge cases
+			if ratio < 0 {
+				ratio = 0
+			}
+			if ratio > 1 {
+				ratio = 1
+			}
+
+			newLat := p1.Latitude + ratio*(p2.Latitude-p1.Latitude)
+			newLon := p1.Longitude + ratio*(p2.Longitude-p1.Longitude)
+
+			newEle := 0.0
+			p1EleValid := p1.Elevation.NotNull()
+			p2EleValid := p2.Elevation.NotNull()
+			elevationInterpolated := false
+
+			if p1EleValid && p2EleValid {
+				newEle = p1.Elevation.Value() + ratio*(p2.Elevation.Value()-p1.Elevation.Value())
+				elevationInterpolated = true
+			} else if p1EleValid {
+				newEle = p1.Elevation.Value()
+				elevationInterpolated = true
+			} else if p2EleValid {
+				newEle = p2.Elevation.Value()
+				elevationInterpolated = true
+			}
+
+			newPoint = gpx.GPXPoint{Point: gpx.Point{Latitude: newLat, Longitude: newLon}, Timestamp: p1.Timestamp} // Use p1's timestamp
+
+			if math.IsNaN(newPoint.Latitude) || math.IsNaN(newPoint.Longitude) {
+				// Fallback if interpolation results in NaN (e.g., p1 and p2 are identical)
+				newPoint.Latitude = p1.Latitude
+				newPoint.Longitude = p1.Longitude
+			}
+
+			if elevationInterpolated {
+				newPoint.Elevation = *gpx.NewNullableFloat64(newEle)
+			}
+		}
+		newPoints = append(newPoints, newPoint)
+	}
+
+	return newPoints
+}
+
+// resampleByTime produces targetCount points evenly spaced in time, using
+// the timestamps carried by sourcePoints. Lat/lon/elevation are
+// interpolated between the two source points enclosing each target
+// timestamp, and the target timestamp itself is emitted so the equal
+// time-spacing invariant survives into the output. It returns an error if
+// fewer than 2 source points carry a timestamp, or if the first or last
+// source point lacks one, since totalDuration is measured between those
+// two specifically.
+func resampleByTime(sourcePoints []gpx.GPXPoint, targetCount int) ([]gpx.GPXPoint, error) {
+	timestamped := 0
+	for _, p := range sourcePoints {
+		if !p.Timestamp.IsZero() {
+			timestamped++
+		}
+	}
+	if timestamped < 2 {
+		return nil, fmt.Errorf("mode=%s requires at least 2 points with timestamps, found %d", modeTime, timestamped)
+	}
+	if sourcePoints[0].Timestamp.IsZero() || sourcePoints[len(sourcePoints)-1].Timestamp.IsZero() {
+		return nil, fmt.Errorf("mode=%s requires the first and last points to carry timestamps", modeTime)
+	}
+
+	first := sourcePoints[0]
+	last := sourcePoints[len(sourcePoints)-1]
+	totalDuration := last.Timestamp.Sub(first.Timestamp)
+	interval := totalDuration / time.Duration(targetCount-1)
+
+	newPoints := make([]gpx.GPXPoint, 0, targetCount)
+	originalPointIndex := 0
+
+	for i := 0; i < targetCount; i++ {
+		var newPoint gpx.GPXPoint
+
+		if i == 0 {
+			newPoint = first
+		} else if i == targetCount-1 {
+			newPoint = last
+		} else {
+			targetTime := first.Timestamp.Add(time.Duration(i) * interval)
+
+			for originalPointIndex < len(sourcePoints)-2 &&
+				sourcePoints[originalPointIndex+1].Timestamp.Before(targetTime) {
+				originalPointIndex++
+			}
+
+			p1 := sourcePoints[originalPointIndex]
+			p2 := sourcePoints[originalPointIndex+1]
+
+			segmentDuration := p2.Timestamp.Sub(p1.Timestamp)
+			ratio := 0.0
+			if segmentDuration > 0 {
+				ratio = float64(targetTime.Sub(p1.Timestamp)) / float64(segmentDuration)
+			}
+			if ratio < 0 {
+				ratio = 0
+			}
+			if ratio > 1 {
+				ratio = 1
+			}
+
+			newLat := p1.Latitude + ratio*(p2.Latitude-p1.Latitude)
+			newLon := p1.Longitude + ratio*(p2.Longitude-p1.Longitude)
+
+			newEle := 0.0
+			p1EleValid := p1.Elevation.NotNull()
+			p2EleValid := p2.Elevation.NotNull()
+			elevationInterpolated := false
+
+			if p1EleValid && p2EleValid {
+				newEle = p1.Elevation.Value() + ratio*(p2.Elevation.Value()-p1.Elevation.Value())
+				elevationInterpolated = true
+			} else if p1EleValid {
+				newEle = p1.Elevation.Value()
+				elevationInterpolated = true
+			} else if p2EleValid {
+				newEle = p2.Elevation.Value()
+				elevationInterpolated = true
+			}
+
+			newPoint = gpx.GPXPoint{Point: gpx.Point{Latitude: newLat, Longitude: newLon}, Timestamp: targetTime}
+
+			if math.IsNaN(newPoint.Latitude) || math.IsNaN(newPoint.Longitude) {
+				newPoint.Latitude = p1.Latitude
+				newPoint.Longitude = p1.Longitude
+			}
+
+			if elevationInterpolated {
+				newPoint.Elevation = *gpx.NewNullableFloat64(newEle)
+			}
+		}
+		newPoints = append(newPoints, newPoint)
+	}
+
+	return newPoints, nil
+}
+
+// resampleUnit resamples a single polyline (a track segment or a route) to
+// targetCount points according to mode, dispatching to resampleByTime or
+// resampleEquidistant as appropriate.
+func resampleUnit(points []gpx.GPXPoint, mode string, targetCount int) ([]gpx.GPXPoint, error) {
+	if mode == modeTime {
+		return resampleByTime(points, targetCount)
+	}
+	return resampleEquidistant(points, distanceFuncForMode(mode), targetCount), nil
+}
+
+// unitWeight measures a polyline's share of a multi-unit file for
+// --per-file proportional allocation: duration for modeTime, distance
+// (via the mode's distanceFunc) otherwise. For modeTime it returns an
+// error if the first or last point lacks a timestamp, mirroring
+// resampleByTime's own requirement.
+func unitWeight(points []gpx.GPXPoint, mode string) (float64, error) {
+	if mode == modeTime {
+		first, last := points[0], points[len(points)-1]
+		if first.Timestamp.IsZero() || last.Timestamp.IsZero() {
+			return 0, fmt.Errorf("mode=%s requires the first and last points to carry timestamps", modeTime)
+		}
+		return float64(last.Timestamp.Sub(first.Timestamp)), nil
+	}
+	return totalLength(points, distanceFuncForMode(mode)), nil
+}
+
+// allocateCounts splits total across len(weights) units proportionally to
+// weight, giving every unit at least minCount points and using the largest
+// remainder method so the allocated counts sum to exactly total. Units with
+// zero (or all-zero) weight split the total evenly instead.
+func allocateCounts(weights []float64, total int, minCount int) []int {
+	n := len(weights)
+	counts := make([]int, n)
+	if n == 1 {
+		counts[0] = total
+		return counts
+	}
+
+	totalWeight := 0.0
+	for _, w := range weights {
+		totalWeight += w
+	}
+
+	remainders := make([]float64, n)
+	assigned := 0
+	for i, w := range weights {
+		share := float64(total) / float64(n)
+		if totalWeight > 0 {
+			share = float64(total) * (w / totalWeight)
+		}
+		counts[i] = int(math.Floor(share))
+		if counts[i] < minCount {
+			counts[i] = minCount
+		}
+		remainders[i] = share - math.Floor(share)
+		assigned += counts[i]
+	}
+
+	// Distribute the remaining points (can be negative if minCount padding
+	// pushed us over budget) to the units with the largest fractional share.
+	remaining := total - assigned
+	for remaining != 0 {
+		bestIdx := -1
+		for i, r := range remainders {
+			if remaining > 0 {
+				if counts[i] >= minCount && (bestIdx == -1 || r > remainders[bestIdx]) {
+					bestIdx = i
+				}
+			} else {
+				if counts[i] > minCount && (bestIdx == -1 || r < remainders[bestIdx]) {
+					bestIdx = i
+				}
+			}
+		}
+		if bestIdx == -1 {
+			break
+		}
+		// Don't pick the same unit again this pass: the add branch picks the
+		// largest remainder and the remove branch the smallest.
+		if remaining > 0 {
+			counts[bestIdx]++
+			remaining--
+			remainders[bestIdx] = -1
+		} else {
+			counts[bestIdx]--
+			remaining++
+			remainders[bestIdx] = math.Inf(1)
+		}
+	}
+
+	return counts
+}
+
+const earthRadiusMeters = 6371000.0
+
+// equirectangular projects p to local planar meters around an origin
+// (originLat, originLon, in degrees), using the standard small-area
+// equirectangular approximation. Good enough for the short chords RDP
+// measures perpendicular distance against.
+func equirectangular(p *gpx.GPXPoint, originLat, originLon float64) (x, y float64) {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	x = toRad(p.Longitude-originLon) * math.Cos(toRad(originLat)) * earthRadiusMeters
+	y = toRad(p.Latitude-originLat) * earthRadiusMeters
+	return x, y
+}
+
+// perpendicularDistanceMeters returns the distance, in meters, from p to
+// the chord a-b. It projects all three points into a local ENU frame
+// centered on the chord's midpoint so the distance can be computed with
+// ordinary planar point-to-line geometry.
+func perpendicularDistanceMeters(p, a, b *gpx.GPXPoint) float64 {
+	originLat := (a.Latitude + b.Latitude) / 2
+	originLon := (a.Longitude + b.Longitude) / 2
+
+	px, py := equirectangular(p, originLat, originLon)
+	ax, ay := equirectangular(a, originLat, originLon)
+	bx, by := equirectangular(b, originLat, originLon)
+
+	dx := bx - ax
+	dy := by - ay
+	if dx == 0 && dy == 0 {
+		return math.Hypot(px-ax, py-ay)
+	}
+	return math.Abs(dy*(px-ax)-dx*(py-ay)) / math.Hypot(dx, dy)
+}
+
+// simplifySegment implements Ramer-Douglas-Peucker: it keeps the first and
+// last point of points, finds the point with the maximum perpendicular
+// distance from the chord between them, and recurses on the two
+// sub-polylines split at that point whenever the distance exceeds epsilon
+// meters. Polylines shorter than 3 points are returned unchanged.
+func simplifySegment(points []gpx.GPXPoint, epsilon float64) []gpx.GPXPoint {
+	if len(points) < 3 {
+		return points
+	}
+
+	first := &points[0]
+	last := &points[len(points)-1]
+
+	maxDist := -1.0
+	maxIdx := 0
+	for i := 1; i < len(points)-1; i++ {
+		dist := perpendicularDistanceMeters(&points[i], first, last)
+		if dist > maxDist {
+			maxDist = dist
+			maxIdx = i
+		}
+	}
+
+	if maxDist > epsilon {
+		left := simplifySegment(points[:maxIdx+1], epsilon)
+		right := simplifySegment(points[maxIdx:], epsilon)
+		// left's last point and right's first point are both points[maxIdx]; drop the duplicate joint.
+		return append(left[:len(left)-1], right...)
+	}
+
+	return []gpx.GPXPoint{*first, *last}
+}
+
+// epsilonForTargetCount binary-searches for the smallest epsilon whose
+// simplifySegment result has at most targetCount points, giving a
+// size-bounded alternative to picking epsilon directly.
+func epsilonForTargetCount(points []gpx.GPXPoint, targetCount int) float64 {
+	lo, hi := 0.0, totalLength(points, distance2D)
+	if hi == 0 {
+		return 0
+	}
+
+	const maxIterations = 40
+	for i := 0; i < maxIterations; i++ {
+		mid := (lo + hi) / 2
+		if len(simplifySegment(points, mid)) > targetCount {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return hi
+}
+
+// simplifyUnit runs simplifySegment over a single polyline (a track segment
+// or a route), using opts.targetPoints to pick epsilon via
+// epsilonForTargetCount when set, otherwise opts.epsilon directly.
+func simplifyUnit(points []gpx.GPXPoint, opts normalizeOptions) []gpx.GPXPoint {
+	epsilon := opts.epsilon
+	if opts.targetPoints > 0 {
+		epsilon = epsilonForTargetCount(points, opts.targetPoints)
+	}
+	return simplifySegment(points, epsilon)
+}
+
+// normalizeGPX reads the GPX file at inputFile, validates every track
+// segment and route against opts.onInvalid (see validateSourcePoints),
+// resamples what survives, preserves waypoints unchanged, and writes the
+// result to outputFile. opts.mode selects how points are spaced: mode2D
+// (the default) resamples along flat lat/lon distance, mode3D also
+// accounts for elevation change, and modeTime spaces points evenly in time
+// using the source timestamps. By default each segment/route is resampled
+// independently to numTargetPoints; with opts.perFile set, numTargetPoints
+// is instead split across all of them proportionally to their share of the
+// file's total length (or duration, in modeTime). modeSimplify instead runs
+// simplifyUnit on each segment/route, so neither numTargetPoints nor
+// opts.perFile applies to it.
+//
+// ctx is checked before any work begins so a caller running a batch of
+// files (see main.go's worker pool) can cancel the remaining ones; it is
+// not threaded further down into the resampling loops since a single
+// file's normalization is not itself long-running enough to warrant it.
+func normalizeGPX(ctx context.Context, inputFile string, outputFile string, opts normalizeOptions) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("normalization of %s canceled: %w", inputFile, err)
+	}
+
 	// Read the GPX file
 	gpxData, err := os.ReadFile(inputFile)
 	if err != nil {
@@ -22,149 +620,250 @@ func normalizeGPX(inputFile string, outputFile string) error {
 		return fmt.Errorf("error parsing GPX file %s: %w", inputFile, err)
 	}
 
-	// Get the first track and segment
-	if len(gpxFile.Tracks) == 0 {
-		return fmt.Errorf("no tracks found in GPX file %s", inputFile)
+	var units []unit
+	for trackIdx := range gpxFile.Tracks {
+		track := &gpxFile.Tracks[trackIdx]
+		for segIdx := range track.Segments {
+			units = append(units, unit{
+				points:   track.Segments[segIdx].Points,
+				describe: fmt.Sprintf("track %d, segment %d", trackIdx, segIdx),
+				name:     track.Name,
+			})
+		}
 	}
-	track := &gpxFile.Tracks[0]
-
-	if len(track.Segments) == 0 {
-		return fmt.Errorf("no segments found in track of GPX file %s", inputFile)
+	for routeIdx := range gpxFile.Routes {
+		units = append(units, unit{
+			points:   gpxFile.Routes[routeIdx].Points,
+			describe: fmt.Sprintf("route %d", routeIdx),
+			name:     gpxFile.Routes[routeIdx].Name,
+		})
 	}
-	segment := &track.Segments[0]
-	sourcePoints := segment.Points
 
-	// Check if the segment has at least 2 points
-	if len(sourcePoints) < 2 {
-		return fmt.Errorf("not enough points in GPX file %s (found %d, need at least 2)", inputFile, len(sourcePoints))
+	if len(units) == 0 {
+		return fmt.Errorf("no track segments or routes found in GPX file %s", inputFile)
 	}
 
-	// Create a new GPX object
-	newGpx := &gpx.GPX{}
-	newGpx.Creator = "gpx-normalizer"
-	newGpx.Version = gpxFile.Version
-	newGpx.Name = gpxFile.Name
-	newGpx.Description = gpxFile.Description
-	newGpx.AuthorName = gpxFile.AuthorName
-	newGpx.CopyrightAuthor = gpxFile.CopyrightAuthor
-	newGpx.CopyrightYear = gpxFile.CopyrightYear
-	newGpx.CopyrightLicense = gpxFile.CopyrightLicense
-	newGpx.Link = gpxFile.Link
-	newGpx.LinkText = gpxFile.LinkText
-	newGpx.Time = gpxFile.Time
-	newGpx.Keywords = gpxFile.Keywords
-	newGpx.Bounds = gpxFile.Bounds
-	newGpx.Extensions = gpxFile.Extensions
-
+	for i, u := range units {
+		validated, err := validateSourcePoints(u.points, opts)
+		if err != nil {
+			return fmt.Errorf("error validating %s of GPX file %s: %w", u.describe, inputFile, err)
+		}
+		units[i].points = validated
+	}
 
-	// Create a new GPXTrack and add it to the new GPX object
-	newTrack := gpx.GPXTrack{}
-	newGpx.Tracks = append(newGpx.Tracks, newTrack)
+	for _, u := range units {
+		if len(u.points) < 2 {
+			return fmt.Errorf("not enough points in %s of GPX file %s (found %d, need at least 2)", u.describe, inputFile, len(u.points))
+		}
+	}
 
-	// Create a new GPXTrackSegment and add it to the new track
-	newSegment := gpx.GPXTrackSegment{}
-	newGpx.Tracks[0].Segments = append(newGpx.Tracks[0].Segments, newSegment)
-	newSegmentPoints := &newGpx.Tracks[0].Segments[0].Points // Pointer to the new points slice
+	outputUnits := make([]unit, len(units))
 
-	totalDistance := segment.Length2D()
+	if opts.mode == modeSimplify {
+		for i, u := range units {
+			outputUnits[i] = unit{points: simplifyUnit(u.points, opts), describe: u.describe, name: u.name}
+		}
+		return writeOutput(gpxFile, outputUnits, outputFile, opts)
+	}
 
-	// Handle zero total distance
-	if totalDistance == 0 {
-		if len(sourcePoints) > 0 {
-			firstPoint := sourcePoints[0]
-			for i := 0; i < numTargetPoints; i++ {
-				*newSegmentPoints = append(*newSegmentPoints, firstPoint)
+	targetCounts := make([]int, len(units))
+	if opts.perFile {
+		weights := make([]float64, len(units))
+		for i, u := range units {
+			weight, err := unitWeight(u.points, opts.mode)
+			if err != nil {
+				return fmt.Errorf("error weighting %s of GPX file %s: %w", u.describe, inputFile, err)
 			}
+			weights[i] = weight
 		}
-		// Proceed to write the file and return (handled later)
+		targetCounts = allocateCounts(weights, numTargetPoints, 2)
 	} else {
-		intervalDistance := totalDistance / float64(numTargetPoints-1)
-		cumulativeDistance := 0.0
-		originalPointIndex := 0
+		for i := range units {
+			targetCounts[i] = numTargetPoints
+		}
+	}
 
-		for i := 0; i < numTargetPoints; i++ {
-			var newPoint gpx.GPXPoint
+	for i, u := range units {
+		resampled, err := resampleUnit(u.points, opts.mode, targetCounts[i])
+		if err != nil {
+			return fmt.Errorf("error resampling %s of GPX file %s: %w", u.describe, inputFile, err)
+		}
+		outputUnits[i] = unit{points: resampled, describe: u.describe, name: u.name}
+	}
 
-			if i == 0 {
-				newPoint = sourcePoints[0]
-			} else if i == numTargetPoints-1 {
-				newPoint = sourcePoints[len(sourcePoints)-1]
-			} else {
-				targetDistForCurrentPoint := float64(i) * intervalDistance
-
-				// Advance originalPointIndex:
-				// Loop while originalPointIndex is not the second to last point AND
-				// the next segment's end (cumulativeDistance + distance to next point) is still less than our target.
-				for originalPointIndex < len(sourcePoints)-2 && // Ensures sourcePoints[originalPointIndex+1] is valid
-					cumulativeDistance+sourcePoints[originalPointIndex].Distance2D(&sourcePoints[originalPointIndex+1]) < targetDistForCurrentPoint {
-					cumulativeDistance += sourcePoints[originalPointIndex].Distance2D(&sourcePoints[originalPointIndex+1])
-					originalPointIndex++
-				}
+	return writeOutput(gpxFile, outputUnits, outputFile, opts)
+}
 
-				p1 := sourcePoints[originalPointIndex]
-				p2 := sourcePoints[originalPointIndex+1] // Safe because originalPointIndex <= len(sourcePoints)-2
+// output formats supported by writeOutput.
+const (
+	outputFormatGPX     = "gpx"
+	outputFormatGeoJSON = "geojson"
+	outputFormatCSV     = "csv"
+)
 
-				distToP1 := cumulativeDistance // Cumulative distance *to the start of the current segment (p1)*
-				distP1P2 := p1.Distance2D(&p2)
+// writeOutput serializes outputUnits' resampled points to outputFile in
+// opts.outputFormat, defaulting to outputFormatGPX for the zero value.
+// GeoJSON and CSV work directly off outputUnits' plain []gpx.GPXPoint
+// slices; only the GPX writer needs gpxFile, to carry over metadata.
+func writeOutput(gpxFile *gpx.GPX, outputUnits []unit, outputFile string, opts normalizeOptions) error {
+	switch opts.outputFormat {
+	case outputFormatGeoJSON:
+		return writeGeoJSON(outputUnits, outputFile)
+	case outputFormatCSV:
+		return writeCSV(outputUnits, outputFile)
+	default:
+		resampledUnits := make([][]gpx.GPXPoint, len(outputUnits))
+		for i, u := range outputUnits {
+			resampledUnits[i] = u.points
+		}
+		return writeNormalizedGPX(gpxFile, resampledUnits, outputFile)
+	}
+}
 
-				ratio := 0.0
-				if distP1P2 > 0 {
-					// ratio is how far along the segment (p1 to p2) our targetDistForCurrentPoint falls
-					ratio = (targetDistForCurrentPoint - distToP1) / distP1P2
-				}
-				// Clamp ratio to [0, 1] to handle floating point inaccuracies or edge cases
-				if ratio < 0 { ratio = 0 }
-				if ratio > 1 { ratio = 1 }
-
-				newLat := p1.Latitude + ratio*(p2.Latitude-p1.Latitude)
-				newLon := p1.Longitude + ratio*(p2.Longitude-p1.Longitude)
-				
-				newEle := 0.0
-				p1EleValid := p1.Elevation.NullFloat64.Valid
-				p2EleValid := p2.Elevation.NullFloat64.Valid
-				elevationInterpolated := false
-
-				if p1EleValid && p2EleValid {
-					newEle = p1.Elevation.Value() + ratio*(p2.Elevation.Value()-p1.Elevation.Value())
-					elevationInterpolated = true
-				} else if p1EleValid {
-					newEle = p1.Elevation.Value()
-					elevationInterpolated = true
-				} else if p2EleValid {
-					newEle = p2.Elevation.Value()
-					elevationInterpolated = true
-				}
+// geoJSONFeatureCollection, geoJSONFeature, geoJSONLineString, and
+// geoJSONProperties mirror just enough of RFC 7946 for writeGeoJSON's
+// needs: a FeatureCollection of LineString features.
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
 
-				newPoint = gpx.GPXPoint{Latitude: newLat, Longitude: newLon, Timestamp: p1.Timestamp} // Use p1's timestamp
+type geoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   geoJSONLineString `json:"geometry"`
+	Properties geoJSONProperties `json:"properties"`
+}
 
-				if math.IsNaN(newPoint.Latitude) || math.IsNaN(newPoint.Longitude) {
-					// Fallback if interpolation results in NaN (e.g., p1 and p2 are identical)
-					newPoint.Latitude = p1.Latitude
-					newPoint.Longitude = p1.Longitude
-				}
+type geoJSONLineString struct {
+	Type        string      `json:"type"`
+	Coordinates [][]float64 `json:"coordinates"`
+}
 
-				if elevationInterpolated {
-					newPoint.Elevation = *gpx.NewNullableFloat64(newEle)
-				}
+type geoJSONProperties struct {
+	Name string `json:"name"`
+}
+
+// writeGeoJSON emits outputUnits as a GeoJSON FeatureCollection, one
+// LineString feature per unit, with coordinates as [lon, lat] pairs (or
+// [lon, lat, ele] when elevation is present) per RFC 7946, and the unit's
+// source track/route name carried into properties.name.
+func writeGeoJSON(outputUnits []unit, outputFile string) error {
+	fc := geoJSONFeatureCollection{Type: "FeatureCollection"}
+
+	for _, u := range outputUnits {
+		coordinates := make([][]float64, 0, len(u.points))
+		for _, p := range u.points {
+			coord := []float64{p.Longitude, p.Latitude}
+			if p.Elevation.NotNull() {
+				coord = append(coord, p.Elevation.Value())
 			}
-			*newSegmentPoints = append(*newSegmentPoints, newPoint)
+			coordinates = append(coordinates, coord)
 		}
+		fc.Features = append(fc.Features, geoJSONFeature{
+			Type:       "Feature",
+			Geometry:   geoJSONLineString{Type: "LineString", Coordinates: coordinates},
+			Properties: geoJSONProperties{Name: u.name},
+		})
+	}
+
+	data, err := json.MarshalIndent(fc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling GeoJSON for %s: %w", outputFile, err)
+	}
+	if err := os.WriteFile(outputFile, data, 0644); err != nil {
+		return fmt.Errorf("error writing GeoJSON file %s: %w", outputFile, err)
+	}
+	return nil
+}
+
+// writeCSV emits outputUnits as a single CSV file with an
+// "index,lat,lon,ele,time" header, one row per resampled point across all
+// units in unit order, with a blank ele/time cell when a point lacks
+// elevation or a timestamp.
+func writeCSV(outputUnits []unit, outputFile string) error {
+	f, err := os.Create(outputFile)
+	if err != nil {
+		return fmt.Errorf("error creating CSV file %s: %w", outputFile, err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"index", "lat", "lon", "ele", "time"}); err != nil {
+		return fmt.Errorf("error writing CSV header to %s: %w", outputFile, err)
 	}
 
-	// Ensure newSegment.Points has numTargetPoints (mostly a safeguard).
-	// This padding/truncating should ideally not be needed if the main loop is correct.
-	// For totalDistance == 0, it's explicitly handled to fill all points.
-	// This is mostly a safeguard; the logic above should handle it for totalDistance > 0.
-	// For totalDistance == 0, it's explicitly handled.
-	if len(*newSegmentPoints) < numTargetPoints && len(sourcePoints) > 0 {
-		lastPt := (*newSegmentPoints)[len(*newSegmentPoints)-1]
-		for len(*newSegmentPoints) < numTargetPoints {
-			*newSegmentPoints = append(*newSegmentPoints, lastPt)
+	index := 0
+	for _, u := range outputUnits {
+		for _, p := range u.points {
+			ele := ""
+			if p.Elevation.NotNull() {
+				ele = strconv.FormatFloat(p.Elevation.Value(), 'f', -1, 64)
+			}
+			ts := ""
+			if !p.Timestamp.IsZero() {
+				ts = p.Timestamp.Format(time.RFC3339)
+			}
+			row := []string{
+				strconv.Itoa(index),
+				strconv.FormatFloat(p.Latitude, 'f', -1, 64),
+				strconv.FormatFloat(p.Longitude, 'f', -1, 64),
+				ele,
+				ts,
+			}
+			if err := w.Write(row); err != nil {
+				return fmt.Errorf("error writing CSV row to %s: %w", outputFile, err)
+			}
+			index++
 		}
-	} else if len(*newSegmentPoints) > numTargetPoints { // Truncate if somehow we overshot
-		*newSegmentPoints = (*newSegmentPoints)[:numTargetPoints]
 	}
 
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("error flushing CSV file %s: %w", outputFile, err)
+	}
+	return nil
+}
+
+// writeNormalizedGPX assembles a new GPX document carrying gpxFile's
+// metadata and waypoints plus resampledUnits in place of the original
+// points (in the same order units were collected: all track segments in
+// track/segment order, then all routes), and writes it to outputFile.
+func writeNormalizedGPX(gpxFile *gpx.GPX, resampledUnits [][]gpx.GPXPoint, outputFile string) error {
+	newGpx := &gpx.GPX{}
+	newGpx.Creator = "gpx-normalizer"
+	newGpx.Version = gpxFile.Version
+	newGpx.Name = gpxFile.Name
+	newGpx.Description = gpxFile.Description
+	newGpx.AuthorName = gpxFile.AuthorName
+	newGpx.Copyright = gpxFile.Copyright
+	newGpx.CopyrightYear = gpxFile.CopyrightYear
+	newGpx.CopyrightLicense = gpxFile.CopyrightLicense
+	newGpx.Link = gpxFile.Link
+	newGpx.LinkText = gpxFile.LinkText
+	newGpx.Time = gpxFile.Time
+	newGpx.Keywords = gpxFile.Keywords
+	newGpx.Extensions = gpxFile.Extensions
+	newGpx.Waypoints = gpxFile.Waypoints
+
+	unitIdx := 0
+	for trackIdx := range gpxFile.Tracks {
+		srcTrack := &gpxFile.Tracks[trackIdx]
+		newTrack := gpx.GPXTrack{Name: srcTrack.Name, Description: srcTrack.Description}
+		for range srcTrack.Segments {
+			newTrack.Segments = append(newTrack.Segments, gpx.GPXTrackSegment{Points: resampledUnits[unitIdx]})
+			unitIdx++
+		}
+		newGpx.Tracks = append(newGpx.Tracks, newTrack)
+	}
+	for routeIdx := range gpxFile.Routes {
+		srcRoute := &gpxFile.Routes[routeIdx]
+		newGpx.Routes = append(newGpx.Routes, gpx.GPXRoute{
+			Name:        srcRoute.Name,
+			Description: srcRoute.Description,
+			Points:      resampledUnits[unitIdx],
+		})
+		unitIdx++
+	}
 
 	// Convert the new GPX object to XML bytes
 	xmlBytes, err := newGpx.ToXml(gpx.ToXmlParams{Version: "1.1", Indent: true})
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/tkrajina/gpxgo/gpx"
 )
@@ -23,10 +28,10 @@ func compareGPXPoints(t *testing.T, p1, p2 gpx.GPXPoint, msgAndArgs ...interface
 		t.Errorf("Longitude mismatch: expected %f, got %f. %s", p1.Longitude, p2.Longitude, fmt.Sprint(msgAndArgs...))
 	}
 
-	if p1.Elevation.Valid() != p2.Elevation.Valid() {
-		t.Errorf("Elevation validity mismatch: expected %v, got %v. %s", p1.Elevation.Valid(), p2.Elevation.Valid(), fmt.Sprint(msgAndArgs...))
+	if p1.Elevation.NotNull() != p2.Elevation.NotNull() {
+		t.Errorf("Elevation validity mismatch: expected %v, got %v. %s", p1.Elevation.NotNull(), p2.Elevation.NotNull(), fmt.Sprint(msgAndArgs...))
 	}
-	if p1.Elevation.Valid() { // Only compare values if valid (implicit that p2.Elevation.Valid() is also true due to above check)
+	if p1.Elevation.NotNull() { // Only compare values if valid (implicit that p2.Elevation.NotNull() is also true due to above check)
 		if math.Abs(p1.Elevation.Value()-p2.Elevation.Value()) > 0.001 { // Tolerance for float comparison
 			t.Errorf("Elevation value mismatch: expected %f, got %f. %s", p1.Elevation.Value(), p2.Elevation.Value(), fmt.Sprint(msgAndArgs...))
 		}
@@ -39,7 +44,7 @@ func TestNormalizeGPX_SuccessfulNormalization(t *testing.T) {
 	expectedOutputFile := "normalized-" + filepath.Base(inputFile) // Created in repo root
 	defer os.Remove(expectedOutputFile)
 
-	err := normalizeGPX(inputFile, expectedOutputFile)
+	err := normalizeGPX(context.Background(), inputFile, expectedOutputFile, normalizeOptions{mode: mode2D})
 	if err != nil {
 		t.Fatalf("normalizeGPX(%s, %s) failed: %v", inputFile, expectedOutputFile, err)
 	}
@@ -105,7 +110,7 @@ func TestNormalizeGPX_LessThanTwoPoints(t *testing.T) {
 	outputFile := "normalized-one_point.gpx" 
 	defer os.Remove(outputFile) 
 
-	err := normalizeGPX(inputFile, outputFile)
+	err := normalizeGPX(context.Background(), inputFile, outputFile, normalizeOptions{mode: mode2D})
 	if err == nil {
 		t.Errorf("Expected an error for GPX file with less than two points (%s), but got nil", inputFile)
 	}
@@ -116,7 +121,7 @@ func TestNormalizeGPX_ZeroDistancePoints(t *testing.T) {
 	expectedOutputFile := "normalized-" + filepath.Base(inputFile) 
 	defer os.Remove(expectedOutputFile)
 
-	err := normalizeGPX(inputFile, expectedOutputFile)
+	err := normalizeGPX(context.Background(), inputFile, expectedOutputFile, normalizeOptions{mode: mode2D})
 	if err != nil {
 		t.Fatalf("normalizeGPX(%s, %s) failed: %v", inputFile, expectedOutputFile, err)
 	}
@@ -153,12 +158,295 @@ func TestNormalizeGPX_NonExistentFile(t *testing.T) {
 	outputFile := "normalized-non_existent.gpx" 
 	defer os.Remove(outputFile) 
 
-	err := normalizeGPX(inputFile, outputFile)
+	err := normalizeGPX(context.Background(), inputFile, outputFile, normalizeOptions{mode: mode2D})
 	if err == nil {
 		t.Errorf("Expected an error for non-existent input file (%s), but got nil", inputFile)
 	}
 }
 
+func TestNormalizeGPX_3DMode_MatchesElevationProfile(t *testing.T) {
+	inputFile := filepath.Join(testFileDir, "sample.gpx")
+	expectedOutputFile := "normalized-3d-" + filepath.Base(inputFile)
+	defer os.Remove(expectedOutputFile)
+
+	err := normalizeGPX(context.Background(), inputFile, expectedOutputFile, normalizeOptions{mode: mode3D})
+	if err != nil {
+		t.Fatalf("normalizeGPX(%s, %s, %q) failed: %v", inputFile, expectedOutputFile, mode3D, err)
+	}
+
+	normalizedGpxFile, err := gpx.ParseFile(expectedOutputFile)
+	if err != nil {
+		t.Fatalf("Error parsing normalized GPX file %s: %v", expectedOutputFile, err)
+	}
+	normalizedPoints := normalizedGpxFile.Tracks[0].Segments[0].Points
+	if len(normalizedPoints) != numExpectedPoints {
+		t.Fatalf("Expected %d points, got %d", numExpectedPoints, len(normalizedPoints))
+	}
+
+	originalGpxFile, err := gpx.ParseFile(inputFile)
+	if err != nil {
+		t.Fatalf("Error parsing original GPX file %s: %v", inputFile, err)
+	}
+	originalPoints := originalGpxFile.Tracks[0].Segments[0].Points
+
+	compareGPXPoints(t, originalPoints[0], normalizedPoints[0], "First point mismatch")
+	compareGPXPoints(t, originalPoints[len(originalPoints)-1], normalizedPoints[numExpectedPoints-1], "Last point mismatch")
+
+	// 3D-mode intervals should be equidistant in 3D arc length rather than flat 2D length.
+	totalDistance := totalLength(normalizedPoints, distance3D)
+	if totalDistance > 0 {
+		expectedInterval := totalDistance / float64(numExpectedPoints-1)
+		p1 := normalizedPoints[numExpectedPoints/2-1]
+		p2 := normalizedPoints[numExpectedPoints/2]
+		dist := distance3D(&p1, &p2)
+		tolerance := 0.01
+		relativeDifference := math.Abs(dist-expectedInterval) / expectedInterval
+		if relativeDifference > tolerance {
+			t.Errorf("3D equidistance check failed: expected interval ~%.6f, got %.6f (relative difference %.6f > tolerance %.6f)",
+				expectedInterval, dist, relativeDifference, tolerance)
+		}
+	}
+}
+
+func TestNormalizeGPX_TimeMode_EquidistantTimestamps(t *testing.T) {
+	inputFile := filepath.Join(testFileDir, "sample.gpx")
+	expectedOutputFile := "normalized-time-" + filepath.Base(inputFile)
+	defer os.Remove(expectedOutputFile)
+
+	err := normalizeGPX(context.Background(), inputFile, expectedOutputFile, normalizeOptions{mode: modeTime})
+	if err != nil {
+		t.Fatalf("normalizeGPX(%s, %s, %q) failed: %v", inputFile, expectedOutputFile, modeTime, err)
+	}
+
+	normalizedGpxFile, err := gpx.ParseFile(expectedOutputFile)
+	if err != nil {
+		t.Fatalf("Error parsing normalized GPX file %s: %v", expectedOutputFile, err)
+	}
+	normalizedPoints := normalizedGpxFile.Tracks[0].Segments[0].Points
+	if len(normalizedPoints) != numExpectedPoints {
+		t.Fatalf("Expected %d points, got %d", numExpectedPoints, len(normalizedPoints))
+	}
+
+	originalGpxFile, err := gpx.ParseFile(inputFile)
+	if err != nil {
+		t.Fatalf("Error parsing original GPX file %s: %v", inputFile, err)
+	}
+	originalPoints := originalGpxFile.Tracks[0].Segments[0].Points
+
+	firstTimestamp := normalizedPoints[0].Timestamp
+	lastTimestamp := normalizedPoints[numExpectedPoints-1].Timestamp
+	if !firstTimestamp.Equal(originalPoints[0].Timestamp) {
+		t.Errorf("First timestamp mismatch: expected %v, got %v", originalPoints[0].Timestamp, firstTimestamp)
+	}
+	if !lastTimestamp.Equal(originalPoints[len(originalPoints)-1].Timestamp) {
+		t.Errorf("Last timestamp mismatch: expected %v, got %v", originalPoints[len(originalPoints)-1].Timestamp, lastTimestamp)
+	}
+
+	expectedInterval := lastTimestamp.Sub(firstTimestamp) / time.Duration(numExpectedPoints-1)
+	tolerance := expectedInterval / 100 // 1% tolerance, as per instructions
+
+	testIndices := [][2]int{{0, 1}, {numExpectedPoints/2 - 1, numExpectedPoints / 2}, {numExpectedPoints - 2, numExpectedPoints - 1}}
+	for _, idx := range testIndices {
+		gap := normalizedPoints[idx[1]].Timestamp.Sub(normalizedPoints[idx[0]].Timestamp)
+		diff := gap - expectedInterval
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > tolerance {
+			t.Errorf("Timestamp spacing check failed for points %d-%d: expected interval ~%v, got %v", idx[0], idx[1], expectedInterval, gap)
+		}
+	}
+}
+
+func TestNormalizeGPX_TimeMode_RequiresTimestamps(t *testing.T) {
+	inputFile := filepath.Join(testFileDir, "no_timestamps.gpx")
+	outputFile := "normalized-no_timestamps.gpx"
+	defer os.Remove(outputFile)
+
+	err := normalizeGPX(context.Background(), inputFile, outputFile, normalizeOptions{mode: modeTime})
+	if err == nil {
+		t.Errorf("Expected an error when mode=%s is used on a file without timestamps (%s), but got nil", modeTime, inputFile)
+	}
+}
+
+func TestNormalizeGPX_MultiTrackMultiSegment(t *testing.T) {
+	inputFile := filepath.Join(testFileDir, "multi_track.gpx")
+	expectedOutputFile := "normalized-multi_track.gpx"
+	defer os.Remove(expectedOutputFile)
+
+	err := normalizeGPX(context.Background(), inputFile, expectedOutputFile, normalizeOptions{mode: mode2D})
+	if err != nil {
+		t.Fatalf("normalizeGPX(%s, %s) failed: %v", inputFile, expectedOutputFile, err)
+	}
+
+	originalGpxFile, err := gpx.ParseFile(inputFile)
+	if err != nil {
+		t.Fatalf("Error parsing original GPX file %s: %v", inputFile, err)
+	}
+	normalizedGpxFile, err := gpx.ParseFile(expectedOutputFile)
+	if err != nil {
+		t.Fatalf("Error parsing normalized GPX file %s: %v", expectedOutputFile, err)
+	}
+
+	if len(normalizedGpxFile.Tracks) != len(originalGpxFile.Tracks) {
+		t.Fatalf("Expected %d tracks, got %d", len(originalGpxFile.Tracks), len(normalizedGpxFile.Tracks))
+	}
+	for trackIdx, originalTrack := range originalGpxFile.Tracks {
+		normalizedTrack := normalizedGpxFile.Tracks[trackIdx]
+		if len(normalizedTrack.Segments) != len(originalTrack.Segments) {
+			t.Fatalf("Track %d: expected %d segments, got %d", trackIdx, len(originalTrack.Segments), len(normalizedTrack.Segments))
+		}
+		for segIdx := range originalTrack.Segments {
+			if len(normalizedTrack.Segments[segIdx].Points) != numExpectedPoints {
+				t.Errorf("Track %d, segment %d: expected %d points, got %d", trackIdx, segIdx, numExpectedPoints, len(normalizedTrack.Segments[segIdx].Points))
+			}
+		}
+	}
+}
+
+func TestNormalizeGPX_PreservesWaypointsAndRoutes(t *testing.T) {
+	inputFile := filepath.Join(testFileDir, "waypoints_and_routes.gpx")
+	expectedOutputFile := "normalized-waypoints_and_routes.gpx"
+	defer os.Remove(expectedOutputFile)
+
+	err := normalizeGPX(context.Background(), inputFile, expectedOutputFile, normalizeOptions{mode: mode2D})
+	if err != nil {
+		t.Fatalf("normalizeGPX(%s, %s) failed: %v", inputFile, expectedOutputFile, err)
+	}
+
+	originalGpxFile, err := gpx.ParseFile(inputFile)
+	if err != nil {
+		t.Fatalf("Error parsing original GPX file %s: %v", inputFile, err)
+	}
+	normalizedGpxFile, err := gpx.ParseFile(expectedOutputFile)
+	if err != nil {
+		t.Fatalf("Error parsing normalized GPX file %s: %v", expectedOutputFile, err)
+	}
+
+	if len(normalizedGpxFile.Waypoints) != len(originalGpxFile.Waypoints) {
+		t.Fatalf("Expected %d waypoints preserved, got %d", len(originalGpxFile.Waypoints), len(normalizedGpxFile.Waypoints))
+	}
+	for i, wpt := range originalGpxFile.Waypoints {
+		compareGPXPoints(t, wpt, normalizedGpxFile.Waypoints[i], fmt.Sprintf("Waypoint %d mismatch", i))
+	}
+
+	if len(normalizedGpxFile.Routes) != len(originalGpxFile.Routes) {
+		t.Fatalf("Expected %d routes, got %d", len(originalGpxFile.Routes), len(normalizedGpxFile.Routes))
+	}
+	for routeIdx, route := range normalizedGpxFile.Routes {
+		if len(route.Points) != numExpectedPoints {
+			t.Errorf("Route %d: expected %d points, got %d", routeIdx, numExpectedPoints, len(route.Points))
+		}
+	}
+}
+
+func TestNormalizeGPX_PerFileProportionalAllocation(t *testing.T) {
+	inputFile := filepath.Join(testFileDir, "multi_track.gpx")
+	expectedOutputFile := "normalized-per-file-multi_track.gpx"
+	defer os.Remove(expectedOutputFile)
+
+	err := normalizeGPX(context.Background(), inputFile, expectedOutputFile, normalizeOptions{mode: mode2D, perFile: true})
+	if err != nil {
+		t.Fatalf("normalizeGPX(%s, %s) failed: %v", inputFile, expectedOutputFile, err)
+	}
+
+	normalizedGpxFile, err := gpx.ParseFile(expectedOutputFile)
+	if err != nil {
+		t.Fatalf("Error parsing normalized GPX file %s: %v", expectedOutputFile, err)
+	}
+
+	total := 0
+	for _, track := range normalizedGpxFile.Tracks {
+		for _, seg := range track.Segments {
+			total += len(seg.Points)
+		}
+	}
+	if total != numExpectedPoints {
+		t.Errorf("Expected per-file allocation to sum to %d points, got %d", numExpectedPoints, total)
+	}
+}
+
+func TestSimplifySegment_StraightLineCollapses(t *testing.T) {
+	points := make([]gpx.GPXPoint, 0, 10)
+	for i := 0; i < 10; i++ {
+		points = append(points, gpx.GPXPoint{Point: gpx.Point{Latitude: float64(i) * 0.001, Longitude: 0}})
+	}
+
+	simplified := simplifySegment(points, 1.0)
+	if len(simplified) != 2 {
+		t.Fatalf("Expected a straight line to collapse to 2 points, got %d", len(simplified))
+	}
+	compareGPXPoints(t, points[0], simplified[0], "First point mismatch")
+	compareGPXPoints(t, points[len(points)-1], simplified[1], "Last point mismatch")
+}
+
+func TestSimplifySegment_ZigzagPreservesCorners(t *testing.T) {
+	// A zigzag whose lon=0.05 corners sit roughly 5.5km off the first-to-last chord; epsilon=100m should keep every corner.
+	points := []gpx.GPXPoint{
+		{Point: gpx.Point{Latitude: 0, Longitude: 0}},
+		{Point: gpx.Point{Latitude: 0.05, Longitude: 0.05}},
+		{Point: gpx.Point{Latitude: 0.1, Longitude: 0}},
+		{Point: gpx.Point{Latitude: 0.15, Longitude: 0.05}},
+		{Point: gpx.Point{Latitude: 0.2, Longitude: 0}},
+	}
+
+	simplified := simplifySegment(points, 100)
+	if len(simplified) != len(points) {
+		t.Fatalf("Expected all %d zigzag corners to survive simplification, got %d", len(points), len(simplified))
+	}
+}
+
+func TestNormalizeGPX_SimplifyMode(t *testing.T) {
+	inputFile := filepath.Join(testFileDir, "sample.gpx")
+	expectedOutputFile := "normalized-simplify-" + filepath.Base(inputFile)
+	defer os.Remove(expectedOutputFile)
+
+	err := normalizeGPX(context.Background(), inputFile, expectedOutputFile, normalizeOptions{mode: modeSimplify, epsilon: 5})
+	if err != nil {
+		t.Fatalf("normalizeGPX(%s, %s, mode=simplify) failed: %v", inputFile, expectedOutputFile, err)
+	}
+
+	normalizedGpxFile, err := gpx.ParseFile(expectedOutputFile)
+	if err != nil {
+		t.Fatalf("Error parsing normalized GPX file %s: %v", expectedOutputFile, err)
+	}
+	normalizedPoints := normalizedGpxFile.Tracks[0].Segments[0].Points
+	if len(normalizedPoints) < 2 {
+		t.Fatalf("Expected at least 2 points after simplification, got %d", len(normalizedPoints))
+	}
+
+	originalGpxFile, err := gpx.ParseFile(inputFile)
+	if err != nil {
+		t.Fatalf("Error parsing original GPX file %s: %v", inputFile, err)
+	}
+	originalPoints := originalGpxFile.Tracks[0].Segments[0].Points
+
+	compareGPXPoints(t, originalPoints[0], normalizedPoints[0], "First point mismatch")
+	compareGPXPoints(t, originalPoints[len(originalPoints)-1], normalizedPoints[len(normalizedPoints)-1], "Last point mismatch")
+}
+
+func TestNormalizeGPX_SimplifyMode_TargetPoints(t *testing.T) {
+	inputFile := filepath.Join(testFileDir, "large_sample.gpx")
+	expectedOutputFile := "normalized-simplify-target-" + filepath.Base(inputFile)
+	defer os.Remove(expectedOutputFile)
+
+	const target = 200
+	err := normalizeGPX(context.Background(), inputFile, expectedOutputFile, normalizeOptions{mode: modeSimplify, targetPoints: target})
+	if err != nil {
+		t.Fatalf("normalizeGPX(%s, %s, mode=simplify, target-points=%d) failed: %v", inputFile, expectedOutputFile, target, err)
+	}
+
+	normalizedGpxFile, err := gpx.ParseFile(expectedOutputFile)
+	if err != nil {
+		t.Fatalf("Error parsing normalized GPX file %s: %v", expectedOutputFile, err)
+	}
+	got := len(normalizedGpxFile.Tracks[0].Segments[0].Points)
+	tolerance := target / 10 // binary search converges to "at most target", allow 10% slack
+	if got > target || got < target-tolerance {
+		t.Errorf("Expected roughly %d points (tolerance %d), got %d", target, tolerance, got)
+	}
+}
+
 // Added Test Function
 func TestNormalizeGPX_LargeFile_3000Points(t *testing.T) {
 	inputFile := filepath.Join(testFileDir, "large_sample.gpx")
@@ -166,7 +454,7 @@ func TestNormalizeGPX_LargeFile_3000Points(t *testing.T) {
 
 	defer os.Remove(expectedOutputFile)
 
-	err := normalizeGPX(inputFile, expectedOutputFile)
+	err := normalizeGPX(context.Background(), inputFile, expectedOutputFile, normalizeOptions{mode: mode2D})
 	if err != nil {
 		t.Fatalf("normalizeGPX(%s, %s) failed: %v", inputFile, expectedOutputFile, err)
 	}
@@ -250,3 +538,245 @@ func TestNormalizeGPX_LargeFile_3000Points(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateSourcePoints_NoOpWhenOnInvalidUnset(t *testing.T) {
+	points := []gpx.GPXPoint{
+		{Point: gpx.Point{Latitude: 0, Longitude: 0}},
+		{Point: gpx.Point{Latitude: 200, Longitude: 0}},
+	}
+	validated, err := validateSourcePoints(points, normalizeOptions{})
+	if err != nil {
+		t.Fatalf("validateSourcePoints with unset onInvalid should not validate, got error: %v", err)
+	}
+	if len(validated) != len(points) {
+		t.Fatalf("expected %d points unchanged, got %d", len(points), len(validated))
+	}
+}
+
+func TestValidateSourcePoints_ErrorNamesOffendingIndex(t *testing.T) {
+	points := []gpx.GPXPoint{
+		{Point: gpx.Point{Latitude: 1, Longitude: 1}},
+		{Point: gpx.Point{Latitude: 95, Longitude: 1}},
+	}
+	_, err := validateSourcePoints(points, normalizeOptions{onInvalid: onInvalidError})
+	if err == nil {
+		t.Fatal("expected an error for an out-of-range point")
+	}
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected a *ValidationError, got %T: %v", err, err)
+	}
+	if verr.Index != 1 {
+		t.Fatalf("expected ValidationError.Index 1, got %d", verr.Index)
+	}
+}
+
+func TestValidateSourcePoints_DropRemovesBadPoints(t *testing.T) {
+	points := []gpx.GPXPoint{
+		{Point: gpx.Point{Latitude: 1, Longitude: 1}},
+		{Point: gpx.Point{Latitude: 0, Longitude: 0}},
+		{Point: gpx.Point{Latitude: 200, Longitude: 1}},
+		{Point: gpx.Point{Latitude: 2, Longitude: 2}},
+	}
+	validated, err := validateSourcePoints(points, normalizeOptions{onInvalid: onInvalidDrop})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(validated) != 2 {
+		t.Fatalf("expected the 2 valid points to survive, got %d", len(validated))
+	}
+	compareGPXPoints(t, validated[0], points[0], "first surviving point mismatch")
+	compareGPXPoints(t, validated[1], points[3], "second surviving point mismatch")
+}
+
+func TestValidateSourcePoints_ClampSnapsOutOfRangeCoordinates(t *testing.T) {
+	points := []gpx.GPXPoint{
+		{Point: gpx.Point{Latitude: 1, Longitude: 1}},
+		{Point: gpx.Point{Latitude: 95, Longitude: -185}},
+	}
+	validated, err := validateSourcePoints(points, normalizeOptions{onInvalid: onInvalidClamp})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(validated) != 2 {
+		t.Fatalf("expected both points to survive clamping, got %d", len(validated))
+	}
+	if validated[1].Latitude != 90 || validated[1].Longitude != -180 {
+		t.Fatalf("expected clamped point (90, -180), got (%g, %g)", validated[1].Latitude, validated[1].Longitude)
+	}
+}
+
+func TestValidateSourcePoints_ClampStillDropsZeroIsland(t *testing.T) {
+	points := []gpx.GPXPoint{
+		{Point: gpx.Point{Latitude: 1, Longitude: 1}},
+		{Point: gpx.Point{Latitude: 0, Longitude: 0}},
+	}
+	validated, err := validateSourcePoints(points, normalizeOptions{onInvalid: onInvalidClamp})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(validated) != 1 {
+		t.Fatalf("expected the (0,0) point to be dropped even under clamp, got %d points", len(validated))
+	}
+}
+
+func TestValidateSourcePoints_MaxJumpMetersRejectsGlitch(t *testing.T) {
+	points := []gpx.GPXPoint{
+		{Point: gpx.Point{Latitude: 1, Longitude: 1}},
+		{Point: gpx.Point{Latitude: 1.001, Longitude: 1.001}},
+		{Point: gpx.Point{Latitude: 40, Longitude: 40}}, // glitch: thousands of km from the previous kept point
+		{Point: gpx.Point{Latitude: 1.002, Longitude: 1.002}},
+	}
+	validated, err := validateSourcePoints(points, normalizeOptions{onInvalid: onInvalidDrop, maxJumpMeters: 1000})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(validated) != 3 {
+		t.Fatalf("expected the glitched point to be dropped, got %d points", len(validated))
+	}
+	compareGPXPoints(t, validated[2], points[3], "point after the glitch mismatch")
+}
+
+func TestNormalizeGPX_OnInvalidDrop(t *testing.T) {
+	inputFile := filepath.Join(testFileDir, "invalid_points.gpx")
+	expectedOutputFile := "normalized-invalid-" + filepath.Base(inputFile)
+	defer os.Remove(expectedOutputFile)
+
+	err := normalizeGPX(context.Background(), inputFile, expectedOutputFile, normalizeOptions{mode: mode2D, onInvalid: onInvalidDrop})
+	if err != nil {
+		t.Fatalf("normalizeGPX with onInvalidDrop failed: %v", err)
+	}
+	if _, errStat := os.Stat(expectedOutputFile); os.IsNotExist(errStat) {
+		t.Fatalf("Expected output file %s was not created", expectedOutputFile)
+	}
+}
+
+func TestNormalizeGPX_OnInvalidError(t *testing.T) {
+	inputFile := filepath.Join(testFileDir, "invalid_points.gpx")
+	expectedOutputFile := "normalized-invalid-error-" + filepath.Base(inputFile)
+	defer os.Remove(expectedOutputFile)
+
+	err := normalizeGPX(context.Background(), inputFile, expectedOutputFile, normalizeOptions{mode: mode2D, onInvalid: onInvalidError})
+	if err == nil {
+		t.Fatal("expected normalizeGPX to fail for a file with invalid points under onInvalidError")
+	}
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected the error chain to contain a *ValidationError, got: %v", err)
+	}
+}
+
+func TestWriteGeoJSON_OneLineStringFeaturePerUnit(t *testing.T) {
+	outputFile := "test-output.geojson"
+	defer os.Remove(outputFile)
+
+	units := []unit{
+		{
+			name: "Morning ride",
+			points: []gpx.GPXPoint{
+				{Point: gpx.Point{Latitude: 1, Longitude: 2, Elevation: *gpx.NewNullableFloat64(100)}},
+				{Point: gpx.Point{Latitude: 3, Longitude: 4}},
+			},
+		},
+		{
+			name:   "Lunch loop",
+			points: []gpx.GPXPoint{{Point: gpx.Point{Latitude: 5, Longitude: 6}}},
+		},
+	}
+
+	if err := writeGeoJSON(units, outputFile); err != nil {
+		t.Fatalf("writeGeoJSON failed: %v", err)
+	}
+
+	data, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", outputFile, err)
+	}
+
+	var fc geoJSONFeatureCollection
+	if err := json.Unmarshal(data, &fc); err != nil {
+		t.Fatalf("failed to parse GeoJSON: %v", err)
+	}
+
+	if fc.Type != "FeatureCollection" {
+		t.Fatalf("expected type FeatureCollection, got %q", fc.Type)
+	}
+	if len(fc.Features) != 2 {
+		t.Fatalf("expected 2 features, got %d", len(fc.Features))
+	}
+	if fc.Features[0].Properties.Name != "Morning ride" {
+		t.Fatalf("expected first feature name %q, got %q", "Morning ride", fc.Features[0].Properties.Name)
+	}
+	if len(fc.Features[0].Geometry.Coordinates) != 2 {
+		t.Fatalf("expected 2 coordinates in first feature, got %d", len(fc.Features[0].Geometry.Coordinates))
+	}
+	firstCoord := fc.Features[0].Geometry.Coordinates[0]
+	if len(firstCoord) != 3 || firstCoord[0] != 2 || firstCoord[1] != 1 || firstCoord[2] != 100 {
+		t.Fatalf("expected first coordinate [2, 1, 100], got %v", firstCoord)
+	}
+	secondCoord := fc.Features[0].Geometry.Coordinates[1]
+	if len(secondCoord) != 2 {
+		t.Fatalf("expected a 2-element [lon, lat] coordinate without elevation, got %v", secondCoord)
+	}
+}
+
+func TestWriteCSV_OneRowPerPointAcrossUnits(t *testing.T) {
+	outputFile := "test-output.csv"
+	defer os.Remove(outputFile)
+
+	ts := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	units := []unit{
+		{points: []gpx.GPXPoint{{Point: gpx.Point{Latitude: 1, Longitude: 2}, Timestamp: ts}}},
+		{points: []gpx.GPXPoint{{Point: gpx.Point{Latitude: 3, Longitude: 4}}, {Point: gpx.Point{Latitude: 5, Longitude: 6}}}},
+	}
+
+	if err := writeCSV(units, outputFile); err != nil {
+		t.Fatalf("writeCSV failed: %v", err)
+	}
+
+	data, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", outputFile, err)
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) != 4 { // header + 3 points
+		t.Fatalf("expected 4 lines (header + 3 rows), got %d: %v", len(lines), lines)
+	}
+	if lines[0] != "index,lat,lon,ele,time" {
+		t.Fatalf("unexpected header: %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "0,1,2,,2026-01-02T03:04:05Z") {
+		t.Fatalf("unexpected first row: %q", lines[1])
+	}
+	if !strings.HasPrefix(lines[3], "2,5,6,,") {
+		t.Fatalf("expected the index to keep counting across units, got: %q", lines[3])
+	}
+}
+
+func TestNormalizeGPX_OutputFormatGeoJSON(t *testing.T) {
+	inputFile := filepath.Join(testFileDir, "sample.gpx")
+	outputFile := "normalized-sample.geojson"
+	defer os.Remove(outputFile)
+
+	err := normalizeGPX(context.Background(), inputFile, outputFile, normalizeOptions{mode: mode2D, outputFormat: outputFormatGeoJSON})
+	if err != nil {
+		t.Fatalf("normalizeGPX with outputFormatGeoJSON failed: %v", err)
+	}
+	if _, errStat := os.Stat(outputFile); os.IsNotExist(errStat) {
+		t.Fatalf("Expected output file %s was not created", outputFile)
+	}
+}
+
+func TestNormalizeGPX_OutputFormatCSV(t *testing.T) {
+	inputFile := filepath.Join(testFileDir, "sample.gpx")
+	outputFile := "normalized-sample.csv"
+	defer os.Remove(outputFile)
+
+	err := normalizeGPX(context.Background(), inputFile, outputFile, normalizeOptions{mode: mode2D, outputFormat: outputFormatCSV})
+	if err != nil {
+		t.Fatalf("normalizeGPX with outputFormatCSV failed: %v", err)
+	}
+	if _, errStat := os.Stat(outputFile); os.IsNotExist(errStat) {
+		t.Fatalf("Expected output file %s was not created", outputFile)
+	}
+}
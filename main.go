@@ -1,51 +1,148 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath" // Added for output file path construction
+	"runtime"
+	"strings"
 	"sync"
 )
 
-func main() {
-	flag.Parse() // Parse command-line flags
-
-	files := flag.Args() // Get non-flag arguments (file paths)
+// outputFileFor derives the output path for file given opts.outputFormat:
+// "normalized-<base>" with the extension swapped to match the format
+// (gpx keeps the original extension).
+func outputFileFor(file string, opts normalizeOptions) string {
+	dir := filepath.Dir(file)
+	base := filepath.Base(file)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
 
-	if len(files) == 0 {
-		fmt.Println("Usage: gpx-normalizer <file1.gpx> [file2.gpx] ...")
-		os.Exit(1)
+	switch opts.outputFormat {
+	case outputFormatGeoJSON:
+		ext = ".geojson"
+	case outputFormatCSV:
+		ext = ".csv"
 	}
 
-	var wg sync.WaitGroup
+	return filepath.Join(dir, "normalized-"+stem+ext)
+}
+
+// batchResult summarizes the outcome of a runBatch call.
+type batchResult struct {
+	Succeeded int
+	Failed    int
+}
+
+// runBatch normalizes each file in files using opts, running up to jobs of
+// them concurrently. Progress is logged as "[i/total] file -> status" lines
+// as each file finishes, followed by a final succeeded/failed summary. When
+// continueOnError is false, the first failure cancels ctx so files not yet
+// started are skipped instead of starting new work.
+func runBatch(ctx context.Context, files []string, opts normalizeOptions, jobs int, continueOnError bool) batchResult {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	log.Printf("Starting normalization for %d GPX file(s)...", len(files))
+	total := len(files)
+	sem := make(chan struct{}, jobs)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var completed, succeeded, failed int
 
 	for _, filePath := range files {
-		wg.Add(1) // Increment the WaitGroup counter
+		wg.Add(1)
+		sem <- struct{}{}
 
 		go func(file string) {
-			defer wg.Done() // Decrement the counter when the goroutine completes
+			defer wg.Done()
+			defer func() { <-sem }()
 
-			log.Printf("Processing %s...", file)
+			outputFile := outputFileFor(file, opts)
 
-			// Generate output filename
-			dir := filepath.Dir(file)
-			base := filepath.Base(file)
-			outputFile := filepath.Join(dir, "normalized-"+base)
+			err := normalizeGPX(ctx, file, outputFile, opts)
 
-			err := normalizeGPX(file, outputFile) // Call refactored normalizeGPX
+			mu.Lock()
+			defer mu.Unlock()
+			completed++
 			if err != nil {
-				log.Printf("Error normalizing %s to %s: %v", file, outputFile, err)
+				failed++
+				log.Printf("[%d/%d] %s -> failed: %v", completed, total, file, err)
+				if !continueOnError {
+					cancel()
+				}
 			} else {
-				log.Printf("Successfully normalized %s to %s", file, outputFile)
+				succeeded++
+				log.Printf("[%d/%d] %s -> normalized to %s", completed, total, file, outputFile)
 			}
 		}(filePath)
 	}
 
-	wg.Wait() // Wait for all goroutines to complete
+	wg.Wait()
+
+	log.Printf("Done: %d succeeded, %d failed, %d total", succeeded, failed, total)
+
+	return batchResult{Succeeded: succeeded, Failed: failed}
+}
+
+func main() {
+	mode := flag.String("mode", mode2D, "resampling mode: 2d (flat lat/lon distance), 3d (accounts for elevation), time (even time spacing), or simplify (Ramer-Douglas-Peucker)")
+	perFile := flag.Bool("per-file", false, "split numTargetPoints across all of a file's segments/routes proportionally to length, instead of resampling each one independently")
+	epsilon := flag.Float64("epsilon", 0, "simplification tolerance in meters, used with -mode=simplify")
+	targetPoints := flag.Int("target-points", 0, "with -mode=simplify, binary-search epsilon to hit approximately this many points instead of using -epsilon directly")
+	onInvalid := flag.String("on-invalid", "", "how to handle invalid source points (out-of-range lat/lon, (0,0), or a jump over -max-jump-meters): error, drop, or clamp; leave unset (default) to skip validation")
+	maxJumpMeters := flag.Float64("max-jump-meters", 0, "reject a point whose great-circle jump from the previous kept point exceeds this many meters; 0 disables the check")
+	jobs := flag.Int("jobs", runtime.NumCPU(), "number of files to normalize concurrently")
+	continueOnError := flag.Bool("continue-on-error", true, "if false, cancel remaining files as soon as one fails instead of processing the rest of the batch")
+	outputFormat := flag.String("output-format", outputFormatGPX, "output format: gpx, geojson, or csv")
+	flag.Parse() // Parse command-line flags
+
+	files := flag.Args() // Get non-flag arguments (file paths)
+
+	if len(files) == 0 {
+		fmt.Println("Usage: gpx-normalizer [-mode=2d|3d|time|simplify] [-per-file] [-epsilon=N | -target-points=N] [-on-invalid=error|drop|clamp] [-max-jump-meters=N] [-jobs=N] [-continue-on-error=false] [-output-format=gpx|geojson|csv] <file1.gpx> [file2.gpx] ...")
+		os.Exit(1)
+	}
+
+	if *mode == modeSimplify && *epsilon <= 0 && *targetPoints <= 0 {
+		fmt.Println("mode=simplify requires either -epsilon or -target-points")
+		os.Exit(1)
+	}
+
+	if *onInvalid != "" && *onInvalid != onInvalidError && *onInvalid != onInvalidDrop && *onInvalid != onInvalidClamp {
+		fmt.Printf("invalid -on-invalid value %q: must be one of %s, %s, %s\n", *onInvalid, onInvalidError, onInvalidDrop, onInvalidClamp)
+		os.Exit(1)
+	}
+
+	if *outputFormat != outputFormatGPX && *outputFormat != outputFormatGeoJSON && *outputFormat != outputFormatCSV {
+		fmt.Printf("invalid -output-format value %q: must be one of %s, %s, %s\n", *outputFormat, outputFormatGPX, outputFormatGeoJSON, outputFormatCSV)
+		os.Exit(1)
+	}
+
+	if *jobs < 1 {
+		*jobs = 1
+	}
+
+	opts := normalizeOptions{
+		mode:          *mode,
+		perFile:       *perFile,
+		epsilon:       *epsilon,
+		targetPoints:  *targetPoints,
+		onInvalid:     *onInvalid,
+		maxJumpMeters: *maxJumpMeters,
+		outputFormat:  *outputFormat,
+	}
+
+	log.Printf("Starting normalization for %d GPX file(s) with -jobs=%d...", len(files), *jobs)
+
+	result := runBatch(context.Background(), files, opts, *jobs, *continueOnError)
 
 	log.Println("All GPX files processed.")
+
+	if result.Failed > 0 {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunBatch_SummaryCounts(t *testing.T) {
+	files := []string{
+		filepath.Join(testFileDir, "sample.gpx"),
+		filepath.Join(testFileDir, "one_point.gpx"), // fewer than 2 points: fails validation
+		"non_existent_file.gpx",                     // fails to read
+	}
+	for _, f := range files {
+		defer os.Remove(filepath.Join(filepath.Dir(f), "normalized-"+filepath.Base(f)))
+	}
+
+	result := runBatch(context.Background(), files, normalizeOptions{mode: mode2D}, 2, true)
+
+	if result.Succeeded != 1 {
+		t.Errorf("expected 1 succeeded file, got %d", result.Succeeded)
+	}
+	if result.Failed != 2 {
+		t.Errorf("expected 2 failed files, got %d", result.Failed)
+	}
+}
+
+func TestRunBatch_ContinueOnErrorFalseStopsEarly(t *testing.T) {
+	// jobs=1 makes the batch serial, so the first file (which fails) is
+	// guaranteed to finish, and cancel, before any of the following ones
+	// start. The rest are all otherwise-normalizable files, so the only
+	// thing that can stop them from succeeding is the cancellation.
+	files := []string{
+		"non_existent_file.gpx",
+		filepath.Join(testFileDir, "sample.gpx"),
+		filepath.Join(testFileDir, "sample.gpx"),
+		filepath.Join(testFileDir, "sample.gpx"),
+	}
+	for _, f := range files {
+		defer os.Remove(filepath.Join(filepath.Dir(f), "normalized-"+filepath.Base(f)))
+	}
+
+	stopped := runBatch(context.Background(), files, normalizeOptions{mode: mode2D}, 1, false)
+	if stopped.Succeeded != 0 {
+		t.Errorf("continueOnError=false: expected the cancellation to stop every remaining file, got %d succeeded", stopped.Succeeded)
+	}
+
+	continued := runBatch(context.Background(), files, normalizeOptions{mode: mode2D}, 1, true)
+	if continued.Succeeded != 3 {
+		t.Errorf("continueOnError=true: expected the 3 valid files to succeed despite the first failure, got %d succeeded", continued.Succeeded)
+	}
+
+	if stopped.Succeeded >= continued.Succeeded {
+		t.Errorf("expected continueOnError=false to succeed on fewer files than continueOnError=true, got %d vs %d", stopped.Succeeded, continued.Succeeded)
+	}
+}